@@ -0,0 +1,83 @@
+package fathomrtmp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestHandleStreamAbortsOnContextCancel verifies a hung HandleStream call returns promptly once
+// its context is canceled, instead of blocking for the full simulated stream duration. This is
+// what lets forceDrain actually unstick wg.Wait() on a forceful shutdown
+func TestHandleStreamAbortsOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		HandleStream(ctx, serverConn)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("HandleStream did not return promptly after its context was canceled")
+	}
+}
+
+// TestForceDrainCancelsAndClosesConnections verifies forceDrain cancels every tracked stream's
+// context and closes its connection, the mechanism a forceful shutdown relies on to abort a
+// hung HandleStream rather than waiting on it
+func TestForceDrainCancelsAndClosesConnections(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	connectionsMux.Lock()
+	connections["test-uuid"] = streamHandle{conn: serverConn, cancel: cancel}
+	connectionsMux.Unlock()
+	defer func() {
+		connectionsMux.Lock()
+		delete(connections, "test-uuid")
+		connectionsMux.Unlock()
+	}()
+
+	forceDrain()
+
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("forceDrain did not cancel the stream's context")
+	}
+
+	if _, err := serverConn.Write([]byte("x")); err == nil {
+		t.Fatal("expected forceDrain to have closed the connection")
+	}
+}
+
+// TestConfirmShutdownSendsExactlyOneStatusChange verifies confirmShutdown notifies the instance
+// is ShuttingDown exactly once per call, regardless of which shutdown branch (graceful drain,
+// forceful-first, or timeout-escalated) led startServer to call it
+func TestConfirmShutdownSendsExactlyOneStatusChange(t *testing.T) {
+	notifier := &recordingNotifier{}
+
+	if err := confirmShutdown(notifier); err != nil {
+		t.Fatalf("confirmShutdown: %v", err)
+	}
+
+	count := 0
+	for _, event := range notifier.recorded() {
+		if change, ok := event.(EventStatusChange); ok && change.Status == InstanceStatus.ShuttingDown {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("expected exactly one ShuttingDown status change, got %d", count)
+	}
+}
@@ -0,0 +1,54 @@
+package fathomrtmp
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// enableProfiling toggles the net/http/pprof handlers on the control server.
+// Like the other config values in rtmp.go, this would be passed in rather than hardcoded
+const enableProfiling bool = false
+
+// Metrics bundles the Prometheus collectors used by the control server
+type Metrics struct {
+	ActiveConnections   prometheus.Gauge
+	StreamsTotal        prometheus.Counter
+	StreamDuration      prometheus.Histogram
+	UsageTimerResets    prometheus.Counter
+	SendRequestFailures *prometheus.CounterVec
+}
+
+// NewMetrics constructs and registers a fresh set of collectors against reg. The package-level
+// metrics var below is the only place this is currently called; reg is a parameter (rather than
+// always prometheus.DefaultRegisterer) only so that registering the same collector names twice
+// against the default registry doesn't panic if this is ever called again, e.g. from a test -
+// there is no Option yet to inject a different *Metrics into startServer/Run
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	factory := promauto.With(reg)
+	return &Metrics{
+		ActiveConnections: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "fathomrtmp_active_connections",
+			Help: "Number of RTMP streams currently being handled.",
+		}),
+		StreamsTotal: factory.NewCounter(prometheus.CounterOpts{
+			Name: "fathomrtmp_streams_total",
+			Help: "Total number of streams handled since startup.",
+		}),
+		StreamDuration: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "fathomrtmp_stream_duration_seconds",
+			Help:    "Duration of HandleStream calls.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		UsageTimerResets: factory.NewCounter(prometheus.CounterOpts{
+			Name: "fathomrtmp_usage_timer_resets_total",
+			Help: "Number of times the usage timer was reset by a new connection.",
+		}),
+		SendRequestFailures: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "fathomrtmp_send_request_failures_total",
+			Help: "Failed SendRequest calls, labeled by endpoint and HTTP status.",
+		}, []string{"endpoint", "status"}),
+	}
+}
+
+// metrics is the package-level collector set used by the server and HTTPNotifier code
+var metrics = NewMetrics(prometheus.DefaultRegisterer)
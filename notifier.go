@@ -0,0 +1,322 @@
+package fathomrtmp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// notifierQueueSize bounds how many events can be queued for dispatch before Notify starts
+// dropping them; see asyncNotifier below
+const notifierQueueSize int = 32
+
+// Event is implemented by every event the lifecycle code in rtmp.go can raise. It exists purely as
+// a marker so Notify is restricted to this closed set of concrete event structs
+type Event interface {
+	isEvent()
+}
+
+// EventStartup is raised once the server is listening and ready to take traffic
+type EventStartup struct{}
+
+// EventStatusChange is raised whenever the instance's status transitions
+type EventStatusChange struct {
+	Status instanceStatusType
+}
+
+// EventStreamComplete is raised when HandleStream returns for a given connection
+type EventStreamComplete struct {
+	UUID string
+}
+
+// EventShutdownRequested is raised as soon as a shutdown has been decided, before streams drain
+type EventShutdownRequested struct {
+	Reason ShutdownReasonType
+}
+
+// EventShutdownFailed is raised if server.Shutdown itself returns an error
+type EventShutdownFailed struct {
+	Err error
+}
+
+// EventPanic is raised when handleCrash recovers a panic in one of the spawned goroutines
+type EventPanic struct {
+	Label     string
+	Recovered interface{}
+}
+
+func (EventStartup) isEvent()           {}
+func (EventStatusChange) isEvent()      {}
+func (EventStreamComplete) isEvent()    {}
+func (EventShutdownRequested) isEvent() {}
+func (EventShutdownFailed) isEvent()    {}
+func (EventPanic) isEvent()             {}
+
+// Notifier decouples the lifecycle code from any one way of telling the outside world what's
+// happening, so it can be swapped (HTTP, client-go, a no-op for tests) without touching callers
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// EventSource is implemented by notifiers that can be subscribed to locally, e.g. to fan events
+// out to HTTP watchers
+type EventSource interface {
+	Events() <-chan Event
+}
+
+// asyncNotifier wraps a Notifier so Notify only ever enqueues; a single goroutine drains the
+// queue and performs the real (network) call, so callers never block on it. It also satisfies
+// EventSource by mirroring each dispatched event onto a broadcast channel; that mirror is
+// best-effort, and an event is left off it when the broadcast channel is full
+type asyncNotifier struct {
+	underlying Notifier
+	queue      chan Event
+	broadcast  chan Event
+}
+
+// newAsyncNotifier starts the dispatch goroutine and returns the wrapper
+func newAsyncNotifier(underlying Notifier, bufferSize int) *asyncNotifier {
+	a := &asyncNotifier{
+		underlying: underlying,
+		queue:      make(chan Event, bufferSize),
+		broadcast:  make(chan Event, bufferSize),
+	}
+	go a.run()
+	return a
+}
+
+func (a *asyncNotifier) run() {
+	for event := range a.queue {
+		a.dispatch(event)
+	}
+}
+
+// dispatch handles a single event, recovering any panic so one bad event (e.g. a broken
+// Notify implementation) can't permanently kill the dispatch goroutine
+func (a *asyncNotifier) dispatch(event Event) {
+	defer handleCrash(a.underlying, nil, "asyncNotifier")
+	select {
+	case a.broadcast <- event:
+	default:
+		// Slow/absent subscriber; the broadcast is best-effort, dispatch is not
+	}
+	if err := a.underlying.Notify(context.Background(), event); err != nil {
+		logger.Printf("Error dispatching event %T: %s\n", event, err)
+	}
+}
+
+func (a *asyncNotifier) Notify(ctx context.Context, event Event) error {
+	select {
+	case a.queue <- event:
+		return nil
+	default:
+		logger.Printf("Notifier queue full, dropping event %T\n", event)
+		return fmt.Errorf("notifier queue full")
+	}
+}
+
+func (a *asyncNotifier) Events() <-chan Event {
+	return a.broadcast
+}
+
+// KubeEndpointType is a type for defining Kubernetes API endpoints used by HTTPNotifier
+type KubeEndpointType string
+
+// KubeEndpoint defines the endpoints HTTPNotifier talks to
+var KubeEndpoint = struct {
+	UpdateStatus KubeEndpointType
+	Notify       KubeEndpointType
+	Complete     KubeEndpointType
+}{
+	UpdateStatus: "/update-status",
+	Notify:       "/notify",
+	Complete:     "/complete",
+}
+
+// HTTPNotifier is the original behavior: it POSTs ad-hoc requests to the endpoints above
+type HTTPNotifier struct {
+	baseURL string
+}
+
+// NewHTTPNotifier builds an HTTPNotifier that talks to the given Kubernetes API base URL
+func NewHTTPNotifier(baseURL string) *HTTPNotifier {
+	return &HTTPNotifier{baseURL: baseURL}
+}
+
+func (h *HTTPNotifier) Notify(ctx context.Context, event Event) error {
+	switch e := event.(type) {
+	case EventStartup:
+		return h.updateStatus(InstanceStatus.Running, nil)
+	case EventStatusChange:
+		return h.updateStatus(e.Status, nil)
+	case EventShutdownRequested:
+		params := url.Values{}
+		params.Add("reason", string(e.Reason))
+		return h.updateStatus(InstanceStatus.ShutdownRequested, params)
+	case EventStreamComplete:
+		params := url.Values{}
+		params.Add("uuid", e.UUID)
+		return h.sendRequest(KubeEndpoint.Complete, params)
+	case EventShutdownFailed:
+		return h.notify("Server Shutdown Failed", e.Err.Error())
+	case EventPanic:
+		return h.notify("Panic", fmt.Sprintf("%s: %v", e.Label, e.Recovered))
+	default:
+		return fmt.Errorf("HTTPNotifier: unsupported event type %T", event)
+	}
+}
+
+// updateStatus adds the "status" query param and posts to the UpdateStatus endpoint
+func (h *HTTPNotifier) updateStatus(newStatus instanceStatusType, params url.Values) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Add("status", string(newStatus))
+	return h.sendRequest(KubeEndpoint.UpdateStatus, params)
+}
+
+// notify sends a generic reason/message payload to the Notify endpoint
+func (h *HTTPNotifier) notify(reason, message string) error {
+	u := h.baseURL + string(KubeEndpoint.Notify)
+	payload := map[string]string{
+		"reason":  reason,
+		"message": message,
+	}
+	jsonPayload, _ := json.Marshal(payload)
+	resp, err := http.Post(u, "application/json", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return nil
+}
+
+// sendRequest sends a POST request to a given endpoint with set query parameters
+func (h *HTTPNotifier) sendRequest(endpoint KubeEndpointType, params url.Values) error {
+	// Build the full URL with query parameters
+	u, err := url.Parse(h.baseURL + string(endpoint))
+	if err != nil {
+		logger.Printf("Error parsing URL: %s\n", err)
+		return err
+	}
+	u.RawQuery = params.Encode()
+
+	// Create a new POST request with the URL containing query parameters
+	request, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		logger.Printf("Error creating request: %s\n", err)
+		return err
+	}
+
+	// Send the request
+	client := &http.Client{}
+	resp, err := client.Do(request)
+	if err != nil {
+		logger.Printf("Error sending request: %s\n", err)
+		metrics.SendRequestFailures.WithLabelValues(string(endpoint), "error").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Check the response status
+	if resp.StatusCode != http.StatusOK {
+		logger.Printf("Request to %s returned status %s\n", u.String(), resp.Status)
+		metrics.SendRequestFailures.WithLabelValues(string(endpoint), strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return nil
+}
+
+// NullNotifier discards every event. Useful for tests, or running outside a cluster entirely
+type NullNotifier struct{}
+
+func (NullNotifier) Notify(ctx context.Context, event Event) error {
+	return nil
+}
+
+// ClientGoNotifier replaces the ad-hoc HTTP calls with the real Kubernetes API: it patches the
+// pod's status conditions and emits v1.Event objects via k8s.io/client-go, using in-cluster config
+type ClientGoNotifier struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	podName   string
+}
+
+// NewClientGoNotifier builds a ClientGoNotifier from in-cluster config. namespace/podName are
+// normally read from the downward API (POD_NAMESPACE / POD_NAME env vars)
+func NewClientGoNotifier(namespace, podName string) (*ClientGoNotifier, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading in-cluster config: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("building clientset: %w", err)
+	}
+	return &ClientGoNotifier{clientset: clientset, namespace: namespace, podName: podName}, nil
+}
+
+func (c *ClientGoNotifier) Notify(ctx context.Context, event Event) error {
+	switch e := event.(type) {
+	case EventStartup:
+		return c.patchCondition(ctx, corev1.PodConditionType(InstanceStatus.Running), "ServerStarted")
+	case EventStatusChange:
+		return c.patchCondition(ctx, corev1.PodConditionType(e.Status), "StatusChanged")
+	case EventShutdownRequested:
+		return c.emitEvent(ctx, corev1.EventTypeNormal, "ShutdownRequested", string(e.Reason))
+	case EventStreamComplete:
+		return c.emitEvent(ctx, corev1.EventTypeNormal, "StreamComplete", e.UUID)
+	case EventShutdownFailed:
+		return c.emitEvent(ctx, corev1.EventTypeWarning, "ShutdownFailed", e.Err.Error())
+	case EventPanic:
+		return c.emitEvent(ctx, corev1.EventTypeWarning, "Panic", fmt.Sprintf("%s: %v", e.Label, e.Recovered))
+	default:
+		return fmt.Errorf("ClientGoNotifier: unsupported event type %T", event)
+	}
+}
+
+// patchCondition appends a condition to the pod's status, the client-go equivalent of the old
+// "status" query parameter
+func (c *ClientGoNotifier) patchCondition(ctx context.Context, conditionType corev1.PodConditionType, reason string) error {
+	pod, err := c.clientset.CoreV1().Pods(c.namespace).Get(ctx, c.podName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{
+		Type:               conditionType,
+		Status:             corev1.ConditionTrue,
+		Reason:             reason,
+		LastTransitionTime: metav1.Now(),
+	})
+	_, err = c.clientset.CoreV1().Pods(c.namespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	return err
+}
+
+// emitEvent creates a v1.Event against the pod, the client-go equivalent of notifyKubernetes
+func (c *ClientGoNotifier) emitEvent(ctx context.Context, eventType, reason, message string) error {
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: c.podName + "-",
+			Namespace:    c.namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Pod",
+			Name:      c.podName,
+			Namespace: c.namespace,
+		},
+		Type:    eventType,
+		Reason:  reason,
+		Message: message,
+		Source:  corev1.EventSource{Component: "fathomrtmp"},
+	}
+	_, err := c.clientset.CoreV1().Events(c.namespace).Create(ctx, event, metav1.CreateOptions{})
+	return err
+}
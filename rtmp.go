@@ -1,27 +1,34 @@
 package fathomrtmp
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"net/url"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"runtime/debug"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Configuration values that would presumably be passed in when the pod is being created rather than hardcoded
 // Only the timeouts were specified by the requirements
 const (
-	kubeAPIURL           string = "http://localhost:8080"                // URL for Kubernetes API (for testing)
-	serverPort           string = "localhost:1935"                       // Port for the RTMP server (localhost is included to appease my firewall)
-	instanceId           string = "7263a41b-0b0b-4643-8ed3-ae5c00fcc561" // Unique identifier for the instance
-	lifetimeTimeoutHours int    = 6                                      // Timeout for the server's lifetime
-	usageTimeoutMinutes  int    = 15                                     // Timeout for server inactivity
+	kubeAPIURL                    string = "http://localhost:8080"                // URL for Kubernetes API (for testing)
+	serverPort                    string = "localhost:1935"                       // Port for the RTMP server (localhost is included to appease my firewall)
+	instanceId                    string = "7263a41b-0b0b-4643-8ed3-ae5c00fcc561" // Unique identifier for the instance
+	lifetimeTimeoutHours          int    = 6                                      // Timeout for the server's lifetime
+	usageTimeoutMinutes           int    = 15                                     // Timeout for server inactivity
+	terminationGracePeriodSeconds int    = 30                                     // How long /prestop will wait on draining streams before giving up, mirrors the pod's terminationGracePeriodSeconds
+	gracefulTimeoutSeconds        int    = 30                                     // How long the graceful shutdown phase waits on wg.Wait() before escalating to a forceful one
 )
 
 // Logger to format any information to be logged
@@ -32,20 +39,6 @@ var logger = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
 // Made it a type in case it needs to be expanded upon later
 type Signal struct{}
 
-// KubeEndpointType is a type for defining Kubernetes API endpoints
-type KubeEndpointType string
-
-// KubeEndpoint defines the endpoints for the Kubernetes API
-var KubeEndpoint = struct {
-	UpdateStatus KubeEndpointType
-	Notify       KubeEndpointType
-	Complete     KubeEndpointType
-}{
-	UpdateStatus: "/update-status",
-	Notify:       "/notify",
-	Complete:     "/complete",
-}
-
 // ShutdownReasonType is a type for defining reasons for shutting down
 type ShutdownReasonType string
 
@@ -53,9 +46,11 @@ type ShutdownReasonType string
 var ShutdownReason = struct {
 	Usage    ShutdownReasonType
 	Lifetime ShutdownReasonType
+	Signal   ShutdownReasonType
 }{
 	Usage:    "usage",
 	Lifetime: "lifetime",
+	Signal:   "signal",
 }
 
 // InstanceStatus is a custom type for instance status
@@ -84,72 +79,62 @@ var status instanceStatusType = InstanceStatus.Inactive
 // Mutex for protecting access to the status
 var statusMux sync.Mutex
 
+// streamHandle pairs a stream's connection with the cancel func for its context, so a forceful
+// shutdown can abort HandleStream and close the socket out from under it
+type streamHandle struct {
+	conn   net.Conn
+	cancel context.CancelFunc
+}
+
 // Map to keep track of active connections
-var connections map[string]net.Conn = make(map[string]net.Conn)
+var connections map[string]streamHandle = make(map[string]streamHandle)
 
 // Mutex for protecting access to the connection map
 var connectionsMux sync.Mutex
 
 // HandleStream simulates handling an RTMP stream
-// For testing purposes, it just waits (sleeps)
-func HandleStream(connection net.Conn) {
-	time.Sleep(time.Duration(3) * time.Second)
-}
-
-// SendRequest sends a request to a given endpoint with set parameters
-func SendRequest(req KubeEndpointType, params url.Values) error {
-	// Build the full URL with query parameters
-	u, err := url.Parse(kubeAPIURL + string(req))
-	if err != nil {
-		logger.Printf("Error parsing URL: %s\n", err)
-		return err
-	}
-	u.RawQuery = params.Encode()
-
-	// Create a new POST request with the URL containing query parameters
-	request, err := http.NewRequest("POST", u.String(), nil)
-	if err != nil {
-		logger.Printf("Error creating request: %s\n", err)
-		return err
+// For testing purposes, it just waits (sleeps), but honors ctx so a forceful shutdown can abort it early
+func HandleStream(ctx context.Context, connection net.Conn) {
+	timer := prometheus.NewTimer(metrics.StreamDuration)
+	defer timer.ObserveDuration()
+	select {
+	case <-time.After(time.Duration(3) * time.Second):
+	case <-ctx.Done():
 	}
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(request)
-	if err != nil {
-		logger.Printf("Error sending request: %s\n", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		logger.Printf("Request to %s returned status %s\n", u.String(), resp.Status)
-	}
-	return nil
 }
 
-// notifyKubernetes sends a generic request to the Kubernetes notify endpoint to pass on any important information
-// Currently only used to notify Kubernetes if an error occurs while shutting down the server
-func notifyKubernetes(reason, message string) {
-	url := kubeAPIURL + string(KubeEndpoint.Notify)
-	payload := map[string]string{
-		"reason":  reason,
-		"message": message,
+// CrashHandler is invoked with the recovered value whenever handleCrash intercepts a panic.
+// Unset (nil) by default, since handleCrash already logs and notifies on its own; tests can
+// install one to additionally observe panics or rethrow them
+type CrashHandler func(recovered interface{})
+
+// handleCrash recovers a panic in the calling goroutine, logs the stack, reports it through
+// notifier as an EventPanic labeled with label, and hands the recovered value to crashHandler.
+// It must be deferred directly at the top of every spawned goroutine, e.g.
+// `defer handleCrash(notifier, crashHandler, "usageTimer")`
+func handleCrash(notifier Notifier, crashHandler CrashHandler, label string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	logger.Printf("Recovered from panic in %s: %v\n%s", label, r, debug.Stack())
+	notifier.Notify(context.Background(), EventPanic{Label: label, Recovered: r})
+	if crashHandler != nil {
+		crashHandler(r)
 	}
-	jsonPayload, _ := json.Marshal(payload)
-	http.Post(url, "application/json", bytes.NewBuffer(jsonPayload))
 }
 
 // startLifetimeTimer sends a signal to shut down the server after the configured lifetime has elapsed
-func startLifetimeTimer(shutdownChan chan ShutdownReasonType) {
+func startLifetimeTimer(shutdownChan chan ShutdownReasonType, notifier Notifier, crashHandler CrashHandler) {
+	defer handleCrash(notifier, crashHandler, "lifetimeTimer")
 	time.Sleep(time.Duration(lifetimeTimeoutHours) * time.Hour)
 	logger.Printf("Instance has been alive for %d hours.\n", lifetimeTimeoutHours)
 	shutdownChan <- ShutdownReason.Lifetime
 }
 
 // startUsageTimer sends a signal to shut down the server after the server has gone without a new connection for a configured amount of time
-func startUsageTimer(shutdownChan chan ShutdownReasonType, connectionChan chan Signal) {
+func startUsageTimer(shutdownChan chan ShutdownReasonType, connectionChan chan Signal, notifier Notifier, crashHandler CrashHandler) {
+	defer handleCrash(notifier, crashHandler, "usageTimer")
 	timer := time.NewTimer(time.Duration(usageTimeoutMinutes) * time.Minute)
 	for {
 		select {
@@ -158,6 +143,7 @@ func startUsageTimer(shutdownChan chan ShutdownReasonType, connectionChan chan S
 				<-timer.C
 			}
 			timer.Reset(time.Duration(usageTimeoutMinutes) * time.Minute)
+			metrics.UsageTimerResets.Inc()
 		case <-timer.C:
 			shutdownChan <- ShutdownReason.Usage
 			logger.Printf("Instance has gone %d minutes without receiving a new connection.\n", usageTimeoutMinutes)
@@ -166,47 +152,65 @@ func startUsageTimer(shutdownChan chan ShutdownReasonType, connectionChan chan S
 	}
 }
 
-// updateStatusAndSendRequest updates the status of the instance and notifies Kubernetes of the new status
-func updateStatusAndSendRequest(newStatus instanceStatusType, params url.Values) error {
+// waitForSignal listens for SIGTERM/SIGINT and forwards a graceful shutdown request onto
+// gracefulChan, the same channel the timers use. A second signal escalates to forcefulChan,
+// mirroring a second `kubectl delete pod --force`
+func waitForSignal(gracefulChan chan ShutdownReasonType, forcefulChan chan Signal, notifier Notifier, crashHandler CrashHandler) {
+	defer handleCrash(notifier, crashHandler, "signalWaiter")
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-sigChan
+	logger.Printf("Received signal %s, requesting graceful shutdown.\n", sig)
+	gracefulChan <- ShutdownReason.Signal
+
+	sig = <-sigChan
+	logger.Printf("Received second signal %s, escalating to forceful shutdown.\n", sig)
+	forcefulChan <- Signal{}
+}
+
+// setStatusAndNotify updates the status of the instance and notifies the given Notifier of the change
+func setStatusAndNotify(notifier Notifier, newStatus instanceStatusType) error {
 	statusMux.Lock()
 	status = newStatus
-	if params == nil {
-		params = url.Values{}
-	}
-	params.Add("status", string(newStatus))
-	err := SendRequest(KubeEndpoint.UpdateStatus, params)
+	statusMux.Unlock()
+	err := notifier.Notify(context.Background(), EventStatusChange{Status: newStatus})
 	if err != nil {
-		logger.Printf("Error updating status and sending request: %s\n", err)
+		logger.Printf("Error notifying of status change: %s\n", err)
 	}
-	statusMux.Unlock()
 	return err
 }
 
-// requestShutdown begins the process of shutting down the server by updating the status to ShutdownRequested, and notifies Kubernetes of the request
-func requestShutdown(reason ShutdownReasonType) error {
-	params := url.Values{}
-	params.Add("reason", string(reason))
-	err := updateStatusAndSendRequest(InstanceStatus.ShutdownRequested, params)
+// requestShutdown begins the process of shutting down the server by updating the status to ShutdownRequested, and notifies the Notifier of the request
+func requestShutdown(notifier Notifier, reason ShutdownReasonType) error {
+	statusMux.Lock()
+	status = InstanceStatus.ShutdownRequested
+	statusMux.Unlock()
+	err := notifier.Notify(context.Background(), EventShutdownRequested{Reason: reason})
+	if err != nil {
+		logger.Printf("Error notifying of shutdown request: %s\n", err)
+	}
 	return err
 }
 
-// connectionComplete notifies Kubernetes that HandleStream has completed on a specific connection by UUID
-func connectionComplete(uuid string) error {
-	params := url.Values{}
-	params.Add("uuid", uuid)
-	return SendRequest(KubeEndpoint.Complete, params)
+// connectionComplete notifies the Notifier that HandleStream has completed on a specific connection by UUID
+func connectionComplete(notifier Notifier, uuid string) error {
+	return notifier.Notify(context.Background(), EventStreamComplete{UUID: uuid})
 }
 
-// confirmShutdown sets the instance's status to ShuttingDown and notifies Kubernetes
-func confirmShutdown() error {
+// confirmShutdown sets the instance's status to ShuttingDown and notifies the Notifier
+func confirmShutdown(notifier Notifier) error {
 	logger.Printf("Shutting down and notifying Kubernetes.\n")
-	return updateStatusAndSendRequest(InstanceStatus.ShuttingDown, nil)
+	return setStatusAndNotify(notifier, InstanceStatus.ShuttingDown)
 }
 
-// confirmStartup sets the instance's status to Running and notifies Kubernetes
-func confirmStartup() error {
+// confirmStartup sets the instance's status to Running and notifies the Notifier
+func confirmStartup(notifier Notifier) error {
 	logger.Printf("Confirming startup and notifying Kubernetes.\n")
-	return updateStatusAndSendRequest(InstanceStatus.Running, nil)
+	statusMux.Lock()
+	status = InstanceStatus.Running
+	statusMux.Unlock()
+	return notifier.Notify(context.Background(), EventStartup{})
 }
 
 // sendResponse sends a response to a request given a status and body
@@ -216,6 +220,60 @@ func sendResponse(w http.ResponseWriter, status int, body interface{}) {
 	json.NewEncoder(w).Encode(body)
 }
 
+// handlePreStop blocks until all in-flight streams have completed or the termination grace
+// period elapses, whichever comes first. It's meant to be wired up as a lifecycle.preStop
+// exec/httpGet hook so Kubernetes holds off on SIGKILL while HandleStream goroutines drain
+func handlePreStop(w http.ResponseWriter, r *http.Request, wg *sync.WaitGroup) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(terminationGracePeriodSeconds)*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logger.Println("preStop: all streams drained.")
+	case <-ctx.Done():
+		logger.Println("preStop: termination grace period elapsed with streams still draining.")
+	}
+	sendResponse(w, http.StatusOK, nil)
+}
+
+// forceDrain cancels every in-flight stream's context and closes its connection, so a hung
+// HandleStream call is aborted instead of blocking wg.Wait() indefinitely
+func forceDrain() {
+	connectionsMux.Lock()
+	defer connectionsMux.Unlock()
+	for uuid, handle := range connections {
+		logger.Printf("Forcefully aborting stream %s.\n", uuid)
+		handle.cancel()
+		handle.conn.Close()
+	}
+}
+
+// handleShutdownRequest lets an operator trigger a shutdown out-of-band, e.g. from a script
+// that isn't in a position to send a signal. ?force=true escalates straight to the forceful
+// channel, matching a second SIGTERM
+func handleShutdownRequest(w http.ResponseWriter, r *http.Request, gracefulChan chan ShutdownReasonType, forcefulChan chan Signal) {
+	if r.URL.Query().Get("force") == "true" {
+		select {
+		case forcefulChan <- Signal{}:
+		default:
+		}
+		sendResponse(w, http.StatusOK, nil)
+		return
+	}
+
+	select {
+	case gracefulChan <- ShutdownReason.Signal:
+	default:
+	}
+	sendResponse(w, http.StatusOK, nil)
+}
+
 // getStatus writes a response to a request containing the instance's status and number of active stream connections
 func getStatus(w http.ResponseWriter, r *http.Request) {
 	statusMux.Lock()
@@ -233,55 +291,157 @@ func getStatus(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, http.StatusOK, jsonPayload)
 }
 
+// getHealthz reports 200 as long as the process is alive to handle requests at all
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, http.StatusOK, nil)
+}
+
+// getReadyz reports 200 only while the instance is Running, i.e. not starting up or
+// already on its way out, so a readiness probe can pull it out of rotation early
+func getReadyz(w http.ResponseWriter, r *http.Request) {
+	statusMux.Lock()
+	ready := status == InstanceStatus.Running
+	statusMux.Unlock()
+
+	if !ready {
+		http.Error(w, "Not ready", http.StatusServiceUnavailable)
+		return
+	}
+	sendResponse(w, http.StatusOK, nil)
+}
+
 // StartServer creates and starts a server to listen for requests
-func startServer() {
-	// Create channels for communication between functions
-	shutdownServer := make(chan ShutdownReasonType)
+func startServer(notifier Notifier, crashHandler CrashHandler) {
+	// Create channels for communication between functions.
+	// gracefulShutdown starts the drain; forcefulShutdown escalates an in-progress one. Both are
+	// buffered by one, so one request sent while startServer isn't receiving is held for it; once
+	// that slot is full, the timers and signal waiter block on their send and /shutdown drops its request
+	gracefulShutdown := make(chan ShutdownReasonType, 1)
+	forcefulShutdown := make(chan Signal, 1)
 	connectionChan := make(chan Signal)
 
 	// For concurrency
 	var wg sync.WaitGroup
 
 	// Start timers
-	go startLifetimeTimer(shutdownServer)
-	go startUsageTimer(shutdownServer, connectionChan)
-
-	// Endpoint handlers
-	http.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
-		handleNewStream(w, r, &wg)
+	go startLifetimeTimer(gracefulShutdown, notifier, crashHandler)
+	go startUsageTimer(gracefulShutdown, connectionChan, notifier, crashHandler)
+
+	// Listen for SIGTERM/SIGINT so a pod deletion drains streams instead of being SIGKILLed
+	go waitForSignal(gracefulShutdown, forcefulShutdown, notifier, crashHandler)
+
+	// Endpoint handlers are registered on a dedicated mux rather than http.DefaultServeMux, so
+	// that merely importing "net/http/pprof" for its handler funcs below can't leak pprof's
+	// own init()-registered routes (which only ever land on DefaultServeMux) onto this server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		handleNewStream(w, r, &wg, notifier, crashHandler, connectionChan)
+	})
+	mux.HandleFunc("/status", getStatus)
+	mux.HandleFunc("/prestop", func(w http.ResponseWriter, r *http.Request) {
+		handlePreStop(w, r, &wg)
+	})
+	mux.HandleFunc("/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		handleShutdownRequest(w, r, gracefulShutdown, forcefulShutdown)
 	})
-	http.HandleFunc("/status", getStatus)
+
+	// /watch fans the notifier's event stream out to long-polling HTTP subscribers
+	if source, ok := notifier.(EventSource); ok {
+		broker := newWatchBroker()
+		go broker.run(source, notifier, crashHandler)
+		mux.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+			handleWatch(w, r, broker)
+		})
+	}
+	mux.HandleFunc("/healthz", getHealthz)
+	mux.HandleFunc("/readyz", getReadyz)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	// pprof is only wired up when explicitly enabled, same as kube-scheduler's --profiling flag
+	if enableProfiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
 
 	// Create server
 	server := &http.Server{
-		Addr: serverPort,
+		Addr:    serverPort,
+		Handler: mux,
 	}
 
 	// Start the server and log the status of the listener
 	go func() {
+		defer handleCrash(notifier, crashHandler, "listener")
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Printf("ListenAndServe(): %s\n", err)
 		}
 	}()
 
 	logger.Println("Server started on", serverPort)
-	confirmStartup()
-
-	// Await a signal to shut down from one of the timers
-	shutdownReason := <-shutdownServer
+	confirmStartup(notifier)
+
+	// Phase 1 (graceful): await a shutdown request from a timer, a signal, or /shutdown. A
+	// forceful request arriving before any graceful one aborts in-flight streams immediately,
+	// rather than waiting for phase 2 to notice it
+	var shutdownReason ShutdownReasonType
+	forcedAlready := false
+	select {
+	case shutdownReason = <-gracefulShutdown:
+	case <-forcefulShutdown:
+		shutdownReason = ShutdownReason.Signal
+		forcedAlready = true
+	}
 	logger.Println("Requesting shutdown.")
 
-	// Notify Kubernetes of intent to shut down, but don't lock out any new streams until after a response is given
-	requestShutdown(shutdownReason)
+	// Notify of intent to shut down. This flips status to ShutdownRequested, which is what
+	// actually stops handleNewStream from accepting new streams, so it must happen before
+	// forceDrain() below aborts the in-flight ones - otherwise a stream could land in the
+	// window between the two and be neither rejected nor aborted
+	requestShutdown(notifier, shutdownReason)
+
+	if forcedAlready {
+		logger.Println("Forceful shutdown requested with no prior graceful request; aborting in-flight streams immediately.")
+		forceDrain()
+	}
+
 	connectionsMux.Lock()
 	if len(connections) > 0 {
-		logger.Printf("Waiting on %d streams to complete.\n", len(connections))
+		logger.Printf("Waiting on %d streams to complete (up to %ds before a forceful stop).\n", len(connections), gracefulTimeoutSeconds)
 	}
 	connectionsMux.Unlock()
 
-	// Wait for all current connections to finish before starting the shutdown process
-	wg.Wait()
-	confirmShutdown()
+	// Phase 2 (drain): wait for current connections to finish, but escalate to a forceful abort
+	// on a second shutdown signal or if gracefulTimeoutSeconds elapses
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	if forcedAlready {
+		// Already aborted above; just wait for the goroutines to unwind
+		<-drained
+		logger.Println("All streams aborted.")
+	} else {
+		select {
+		case <-drained:
+			logger.Println("All streams drained gracefully.")
+		case <-forcefulShutdown:
+			logger.Println("Forceful shutdown requested; aborting in-flight streams.")
+			forceDrain()
+			<-drained
+		case <-time.After(time.Duration(gracefulTimeoutSeconds) * time.Second):
+			logger.Println("Graceful timeout elapsed; aborting in-flight streams.")
+			forceDrain()
+			<-drained
+		}
+	}
+
+	// confirmShutdown is only ever reached once, regardless of which branch above fired
+	confirmShutdown(notifier)
 	logger.Println("Shutting down.")
 
 	// Shut down the server
@@ -289,13 +449,23 @@ func startServer() {
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Printf("Server shutdown failed: %s", err)
-		notifyKubernetes("Server Shutdown Failed", err.Error())
+		notifier.Notify(context.Background(), EventShutdownFailed{Err: err})
 	}
 	logger.Println("Server exited")
 }
 
 // handleNewStream prepares a new connection to send to the stream handler
-func handleNewStream(w http.ResponseWriter, r *http.Request, wg *sync.WaitGroup) {
+func handleNewStream(w http.ResponseWriter, r *http.Request, wg *sync.WaitGroup, notifier Notifier, crashHandler CrashHandler, connectionChan chan Signal) {
+	// Reject new streams as soon as shutdown has been requested, rather than letting them
+	// race the wg.Wait() in startServer
+	statusMux.Lock()
+	shuttingDown := status == InstanceStatus.ShutdownRequested || status == InstanceStatus.ShuttingDown
+	statusMux.Unlock()
+	if shuttingDown {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Acquire lock on mutex as soon as possible in case a pending shutdown checks for new connections while function is running
 	connectionsMux.Lock()
 
@@ -322,29 +492,82 @@ func handleNewStream(w http.ResponseWriter, r *http.Request, wg *sync.WaitGroup)
 		return
 	}
 
-	// Add connection to the map of current connections and release mutex
-	connections[streamDetails.UUID] = conn
+	// Add connection to the map of current connections, alongside the cancel func a forceful
+	// shutdown will use to abort HandleStream, and release the mutex
+	streamCtx, cancel := context.WithCancel(context.Background())
+	connections[streamDetails.UUID] = streamHandle{conn: conn, cancel: cancel}
+	metrics.ActiveConnections.Set(float64(len(connections)))
+	metrics.StreamsTotal.Inc()
 	connectionsMux.Unlock()
 
+	// Tell startUsageTimer a connection arrived so it resets the inactivity timeout. Non-blocking
+	// since a new stream shouldn't stall waiting on the timer goroutine to be ready to receive
+	select {
+	case connectionChan <- Signal{}:
+	default:
+	}
+
 	// Respond to new stream creation
 	sendResponse(w, http.StatusOK, nil)
 
 	// Add to the waitgroup and send the connection to HandleStream
 	wg.Add(1)
 	go func() {
+		// Deferred in this order so that, even if HandleStream panics, the connection is
+		// dropped from the map and the waitgroup released before handleCrash recovers -
+		// otherwise a panicking stream would wedge startServer's wg.Wait() forever
+		defer handleCrash(notifier, crashHandler, "stream:"+streamDetails.UUID)
 		defer wg.Done()
-		HandleStream(conn)
-		connectionsMux.Lock()
-		delete(connections, conn.RemoteAddr().String())
-		err := connectionComplete(streamDetails.UUID)
-		if err != nil {
+		defer cancel()
+		defer func() {
+			connectionsMux.Lock()
+			delete(connections, streamDetails.UUID)
+			metrics.ActiveConnections.Set(float64(len(connections)))
+			connectionsMux.Unlock()
+		}()
+
+		HandleStream(streamCtx, conn)
+		if err := connectionComplete(notifier, streamDetails.UUID); err != nil {
 			logger.Printf("Error calling connectionComplete: %s\n", err)
 		}
-		connectionsMux.Unlock()
 	}()
 }
 
-// Run starts the server
-func Run() {
-	startServer()
+// serverConfig holds the configuration built up by a Run's Options
+type serverConfig struct {
+	notifier     Notifier
+	crashHandler CrashHandler
+}
+
+// Option configures the server started by Run
+type Option func(*serverConfig)
+
+// WithNotifier overrides the Notifier used to report lifecycle events, in place of the default
+// HTTPNotifier pointed at kubeAPIURL
+func WithNotifier(notifier Notifier) Option {
+	return func(c *serverConfig) {
+		c.notifier = notifier
+	}
+}
+
+// WithCrashHandler installs a CrashHandler invoked whenever handleCrash recovers a panic, e.g.
+// so tests can assert a panic was observed or rethrow it
+func WithCrashHandler(crashHandler CrashHandler) Option {
+	return func(c *serverConfig) {
+		c.crashHandler = crashHandler
+	}
+}
+
+// Run starts the server, applying any Options over the defaults
+func Run(opts ...Option) {
+	cfg := &serverConfig{
+		notifier: NewHTTPNotifier(kubeAPIURL),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	// Wrap whatever notifier was configured so callers never block on its Notify call
+	notifier := newAsyncNotifier(cfg.notifier, notifierQueueSize)
+	startServer(notifier, cfg.crashHandler)
 }
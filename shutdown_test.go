@@ -0,0 +1,109 @@
+package fathomrtmp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recordingNotifier records every event it's given, in order, so tests can assert on ordering
+// without depending on any particular Notifier implementation's side effects
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event)
+	return nil
+}
+
+func (n *recordingNotifier) recorded() []Event {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make([]Event, len(n.events))
+	copy(out, n.events)
+	return out
+}
+
+// TestWaitForSignalForwardsSIGTERM verifies that a SIGTERM arriving while the server is up is
+// forwarded onto gracefulChan as a signal-triggered shutdown, the same path a pod deletion takes
+func TestWaitForSignalForwardsSIGTERM(t *testing.T) {
+	graceful := make(chan ShutdownReasonType, 1)
+	forceful := make(chan Signal, 1)
+	notifier := &recordingNotifier{}
+
+	go waitForSignal(graceful, forceful, notifier, nil)
+
+	// Give signal.Notify a moment to register before we send anything
+	time.Sleep(50 * time.Millisecond)
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("sending SIGTERM: %v", err)
+	}
+
+	select {
+	case reason := <-graceful:
+		if reason != ShutdownReason.Signal {
+			t.Fatalf("expected ShutdownReason.Signal, got %q", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a graceful shutdown request after SIGTERM")
+	}
+}
+
+// TestRequestShutdownThenConfirmShutdownOrdering verifies the notifier sees
+// EventShutdownRequested before EventStatusChange{ShuttingDown}, mirroring the order
+// startServer fires them in: requestShutdown as soon as a reason is picked, confirmShutdown
+// only after streams have drained (or been aborted)
+func TestRequestShutdownThenConfirmShutdownOrdering(t *testing.T) {
+	notifier := &recordingNotifier{}
+
+	if err := requestShutdown(notifier, ShutdownReason.Signal); err != nil {
+		t.Fatalf("requestShutdown: %v", err)
+	}
+	if err := confirmShutdown(notifier); err != nil {
+		t.Fatalf("confirmShutdown: %v", err)
+	}
+
+	events := notifier.recorded()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %#v", len(events), events)
+	}
+	if _, ok := events[0].(EventShutdownRequested); !ok {
+		t.Fatalf("expected first event to be EventShutdownRequested, got %T", events[0])
+	}
+	change, ok := events[1].(EventStatusChange)
+	if !ok || change.Status != InstanceStatus.ShuttingDown {
+		t.Fatalf("expected second event to be EventStatusChange{ShuttingDown}, got %#v", events[1])
+	}
+}
+
+// TestHandleNewStreamRejectsWhileShuttingDown verifies /stream returns 503 as soon as the
+// instance's status has moved to ShutdownRequested, rather than racing the drain in startServer
+func TestHandleNewStreamRejectsWhileShuttingDown(t *testing.T) {
+	statusMux.Lock()
+	previous := status
+	status = InstanceStatus.ShutdownRequested
+	statusMux.Unlock()
+	defer func() {
+		statusMux.Lock()
+		status = previous
+		statusMux.Unlock()
+	}()
+
+	req := httptest.NewRequest("POST", "/stream", nil)
+	rec := httptest.NewRecorder()
+
+	var wg sync.WaitGroup
+	handleNewStream(rec, req, &wg, &recordingNotifier{}, nil, make(chan Signal, 1))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 Service Unavailable, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package fathomrtmp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// watchRingBufferSize bounds how many past events a reconnecting /watch client can replay via ?resourceVersion=
+const watchRingBufferSize int = 256
+
+// watchSubscriberBuffer bounds how far a single slow /watch client can fall behind (beyond any
+// events replayed to it on subscribe) before the broker starts dropping its oldest buffered event
+// to keep publish() non-blocking
+const watchSubscriberBuffer int = 16
+
+// watchEvent is what gets written to each /watch subscriber, one JSON object per line
+type watchEvent struct {
+	Type            string `json:"type"`
+	Status          string `json:"status"`
+	Streams         int    `json:"streams"`
+	Ts              int64  `json:"ts"`
+	ResourceVersion uint64 `json:"resourceVersion"`
+}
+
+// watchBroker fans the notifier's event stream out to any number of /watch subscribers, each
+// with its own buffered channel so one slow client can't block delivery to the others
+type watchBroker struct {
+	mu              sync.RWMutex
+	subscribers     map[chan watchEvent]struct{}
+	resourceVersion uint64
+	ring            []watchEvent
+}
+
+// newWatchBroker constructs an empty broker; call run to start consuming a notifier's events
+func newWatchBroker() *watchBroker {
+	return &watchBroker{
+		subscribers: make(map[chan watchEvent]struct{}),
+	}
+}
+
+// run consumes source's broadcast channel until it's closed, publishing one watchEvent per Event
+func (b *watchBroker) run(source EventSource, notifier Notifier, crashHandler CrashHandler) {
+	for event := range source.Events() {
+		b.handle(event, notifier, crashHandler)
+	}
+}
+
+// handle publishes a single event, recovering any panic so one bad event can't permanently
+// kill the broker
+func (b *watchBroker) handle(event Event, notifier Notifier, crashHandler CrashHandler) {
+	defer handleCrash(notifier, crashHandler, "watchBroker")
+	b.publish(b.toWatchEvent(event))
+}
+
+// toWatchEvent stamps an Event with the current status/stream count and the next resourceVersion
+func (b *watchBroker) toWatchEvent(event Event) watchEvent {
+	b.mu.Lock()
+	b.resourceVersion++
+	rv := b.resourceVersion
+	b.mu.Unlock()
+
+	statusMux.Lock()
+	currentStatus := string(status)
+	statusMux.Unlock()
+
+	connectionsMux.Lock()
+	streams := len(connections)
+	connectionsMux.Unlock()
+
+	return watchEvent{
+		Type:            fmt.Sprintf("%T", event),
+		Status:          currentStatus,
+		Streams:         streams,
+		Ts:              time.Now().Unix(),
+		ResourceVersion: rv,
+	}
+}
+
+// publish appends event to the replay ring and fans it out to every subscriber, dropping the
+// oldest buffered event for any subscriber that isn't keeping up rather than blocking on it
+func (b *watchBroker) publish(event watchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ring = append(b.ring, event)
+	if len(b.ring) > watchRingBufferSize {
+		b.ring = b.ring[len(b.ring)-watchRingBufferSize:]
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub <- event:
+		default:
+			select {
+			case <-sub:
+			default:
+			}
+			select {
+			case sub <- event:
+			default:
+			}
+		}
+	}
+}
+
+// subscribe registers a new subscriber, replaying any ringed events newer than resourceVersion,
+// and returns its channel plus an unsubscribe func the caller must invoke when done
+func (b *watchBroker) subscribe(resourceVersion uint64) (chan watchEvent, func()) {
+	b.mu.Lock()
+	var replay []watchEvent
+	for _, event := range b.ring {
+		if event.ResourceVersion > resourceVersion {
+			replay = append(replay, event)
+		}
+	}
+	// Sized so the whole replay fits on top of the usual live-event buffer
+	sub := make(chan watchEvent, watchSubscriberBuffer+len(replay))
+	for _, event := range replay {
+		sub <- event
+	}
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		delete(b.subscribers, sub)
+		b.mu.Unlock()
+		close(sub)
+	}
+	return sub, unsubscribe
+}
+
+// handleWatch upgrades the request to a chunked stream of JSON-per-line watchEvents, one per
+// state transition, replaying buffered events newer than ?resourceVersion= first if given
+func handleWatch(w http.ResponseWriter, r *http.Request, broker *watchBroker) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var resourceVersion uint64
+	if rv := r.URL.Query().Get("resourceVersion"); rv != "" {
+		if parsed, err := strconv.ParseUint(rv, 10, 64); err == nil {
+			resourceVersion = parsed
+		}
+	} else {
+		// No resourceVersion given: start from the current one, so nothing is replayed
+		broker.mu.RLock()
+		resourceVersion = broker.resourceVersion
+		broker.mu.RUnlock()
+	}
+
+	sub, unsubscribe := broker.subscribe(resourceVersion)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}